@@ -0,0 +1,198 @@
+package main
+
+import "fmt"
+
+// taskDAG models the dependency relationships between a set of tasks that
+// are scheduled together (namely the tasks produced by Task.Tasks() for a
+// single top level task or parallel-tasks group). Scheduling is keyed off
+// the *Task pointers themselves rather than Name -- a task's Name is
+// user-supplied, or derived from CmdString by inflate() when omitted, and
+// is not guaranteed unique (e.g. two for-each-expanded or parallel tasks
+// sharing a command and no explicit name). Name is only used to resolve a
+// depends-on or when reference back to the task it names.
+type taskDAG struct {
+	tasks []*Task
+	edges map[*Task][]*Task  // task -> tasks it depends on
+	names map[string][]*Task // task name -> every task instance sharing it
+}
+
+// buildTaskDAG indexes tasks by name and validates that every depends-on
+// reference points at a known, unambiguous task and that the resulting
+// graph is acyclic.
+func buildTaskDAG(tasks []*Task) (*taskDAG, error) {
+	dag := &taskDAG{
+		tasks: tasks,
+		edges: make(map[*Task][]*Task, len(tasks)),
+		names: make(map[string][]*Task, len(tasks)),
+	}
+
+	for _, t := range tasks {
+		if t.Name == "" {
+			continue
+		}
+		dag.names[t.Name] = append(dag.names[t.Name], t)
+	}
+
+	for _, t := range tasks {
+		if t.Name == "" {
+			continue
+		}
+		for _, depName := range t.DependsOn {
+			dep, err := dag.resolve(depName)
+			if err != nil {
+				return nil, fmt.Errorf("task %q depends on %s", t.Name, err)
+			}
+			dag.edges[t] = append(dag.edges[t], dep)
+		}
+	}
+
+	if cycle := dag.findCycle(); cycle != nil {
+		return nil, fmt.Errorf("cyclic task dependency detected at %q", cycle.Name)
+	}
+
+	return dag, nil
+}
+
+// resolve maps a user-facing task name (from a depends-on or when clause)
+// to the single task instance it refers to. Duplicate names are otherwise
+// harmless -- see taskDAG -- but a reference to one is ambiguous, so that's
+// an error rather than a silent pick of whichever instance was indexed last.
+func (dag *taskDAG) resolve(name string) (*Task, error) {
+	matches := dag.names[name]
+	switch len(matches) {
+	case 0:
+		return nil, fmt.Errorf("unknown task %q", name)
+	case 1:
+		return matches[0], nil
+	default:
+		return nil, fmt.Errorf("ambiguous task name %q (%d tasks share it)", name, len(matches))
+	}
+}
+
+// hasDependencies reports whether any task in the graph declares a
+// depends-on edge.
+func (dag *taskDAG) hasDependencies() bool {
+	for _, deps := range dag.edges {
+		if len(deps) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// findCycle returns a task participating in a dependency cycle, or nil if
+// the graph is acyclic.
+func (dag *taskDAG) findCycle() *Task {
+	const (
+		white = iota
+		gray
+		black
+	)
+	color := make(map[*Task]int, len(dag.tasks))
+
+	var visit func(t *Task) *Task
+	visit = func(t *Task) *Task {
+		color[t] = gray
+		for _, dep := range dag.edges[t] {
+			switch color[dep] {
+			case gray:
+				return dep
+			case white:
+				if found := visit(dep); found != nil {
+					return found
+				}
+			}
+		}
+		color[t] = black
+		return nil
+	}
+
+	for _, t := range dag.tasks {
+		if t.Name == "" {
+			continue
+		}
+		if color[t] == white {
+			if found := visit(t); found != nil {
+				return found
+			}
+		}
+	}
+	return nil
+}
+
+// ready returns the tasks whose dependencies have all reached a terminal,
+// non-failing status (success or skipped) and that have not yet been
+// started.
+func (dag *taskDAG) ready(started map[*Task]bool) []*Task {
+	var runnable []*Task
+	for _, t := range dag.tasks {
+		if t.Name == "" || started[t] {
+			continue
+		}
+		if dag.satisfied(t) {
+			runnable = append(runnable, t)
+		}
+	}
+	return runnable
+}
+
+func (dag *taskDAG) satisfied(t *Task) bool {
+	for _, dep := range dag.edges[t] {
+		if !dep.Command.Complete {
+			return false
+		}
+		if dep.Command.Status != StatusSuccess && dep.Command.Status != StatusSkipped {
+			return false
+		}
+	}
+	return true
+}
+
+// blocked reports whether t can never become ready because a dependency
+// has already reached a terminal, failing status -- i.e. satisfied(t) will
+// never turn true on its own.
+func (dag *taskDAG) blocked(t *Task) bool {
+	for _, dep := range dag.edges[t] {
+		if dep.Command.Complete && dep.Command.Status != StatusSuccess && dep.Command.Status != StatusSkipped {
+			return true
+		}
+	}
+	return false
+}
+
+// criticalPathSeconds returns the longest chain of estimated task runtimes
+// through the dependency graph, used to compute a DAG-aware ETA.
+func (dag *taskDAG) criticalPathSeconds() float64 {
+	memo := make(map[*Task]float64, len(dag.tasks))
+
+	var walk func(t *Task) float64
+	walk = func(t *Task) float64 {
+		if v, ok := memo[t]; ok {
+			return v
+		}
+
+		var longestUpstream float64
+		for _, dep := range dag.edges[t] {
+			if v := walk(dep); v > longestUpstream {
+				longestUpstream = v
+			}
+		}
+
+		var own float64
+		if t.Command.EstimatedRuntime > 0 {
+			own = t.Command.EstimatedRuntime.Seconds()
+		}
+
+		total := longestUpstream + own
+		memo[t] = total
+		return total
+	}
+
+	var longest float64
+	for _, t := range dag.tasks {
+		if v := walk(t); v > longest {
+			longest = v
+		}
+	}
+	return longest
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func newTestTask(name string, dependsOn ...string) *Task {
+	return &Task{Name: name, DependsOn: dependsOn, Command: TaskCommand{Status: StatusPending}}
+}
+
+func TestBuildTaskDAGUnknownDependency(t *testing.T) {
+	tasks := []*Task{newTestTask("a", "missing")}
+
+	if _, err := buildTaskDAG(tasks); err == nil {
+		t.Fatal("expected an error for a depends-on referencing an unknown task")
+	}
+}
+
+func TestBuildTaskDAGCycle(t *testing.T) {
+	tasks := []*Task{
+		newTestTask("a", "b"),
+		newTestTask("b", "a"),
+	}
+
+	if _, err := buildTaskDAG(tasks); err == nil {
+		t.Fatal("expected an error for a cyclic dependency")
+	}
+}
+
+func TestDAGReadyRespectsDependencies(t *testing.T) {
+	a := newTestTask("a")
+	b := newTestTask("b", "a")
+	dag, err := buildTaskDAG([]*Task{a, b})
+	if err != nil {
+		t.Fatalf("unexpected error building dag: %v", err)
+	}
+
+	started := map[*Task]bool{}
+	ready := dag.ready(started)
+	if len(ready) != 1 || ready[0].Name != "a" {
+		t.Fatalf("expected only %q ready, got %v", "a", names(ready))
+	}
+
+	started[a] = true
+	a.Command.Complete = true
+	a.Command.Status = StatusSuccess
+
+	ready = dag.ready(started)
+	if len(ready) != 1 || ready[0].Name != "b" {
+		t.Fatalf("expected %q ready once %q succeeded, got %v", "b", "a", names(ready))
+	}
+}
+
+func TestDAGBlockedAfterDependencyFailure(t *testing.T) {
+	a := newTestTask("a")
+	b := newTestTask("b", "a")
+	dag, err := buildTaskDAG([]*Task{a, b})
+	if err != nil {
+		t.Fatalf("unexpected error building dag: %v", err)
+	}
+
+	if dag.blocked(b) {
+		t.Fatal("b should not be blocked before a has run")
+	}
+
+	a.Command.Complete = true
+	a.Command.Status = StatusError
+
+	if !dag.blocked(b) {
+		t.Fatal("b should be blocked once its dependency a has failed")
+	}
+	if dag.satisfied(b) {
+		t.Fatal("b should never become satisfied once a has failed")
+	}
+}
+
+func TestDAGSchedulesDuplicateNamedTasks(t *testing.T) {
+	// two unnamed tasks sharing a command (the common shape for parallel or
+	// for-each expanded tasks) end up with the same inflate()-derived Name --
+	// buildTaskDAG must not let the second silently drop the first from
+	// scheduling.
+	a := newTestTask("sleep 1")
+	b := newTestTask("sleep 1")
+
+	dag, err := buildTaskDAG([]*Task{a, b})
+	if err != nil {
+		t.Fatalf("unexpected error building dag: %v", err)
+	}
+
+	ready := dag.ready(map[*Task]bool{})
+	if len(ready) != 2 {
+		t.Fatalf("expected both duplicate-named tasks to be schedulable, got %d: %v", len(ready), names(ready))
+	}
+}
+
+func TestDAGAmbiguousDependsOnNameErrors(t *testing.T) {
+	a := newTestTask("build")
+	dup := newTestTask("build")
+	c := newTestTask("c", "build")
+
+	if _, err := buildTaskDAG([]*Task{a, dup, c}); err == nil {
+		t.Fatal("expected an error for a depends-on referencing an ambiguous (duplicate) task name")
+	}
+}
+
+func TestDAGCriticalPathSeconds(t *testing.T) {
+	a := newTestTask("a")
+	a.Command.EstimatedRuntime = 10 * time.Second
+	b := newTestTask("b", "a")
+	b.Command.EstimatedRuntime = 5 * time.Second
+	c := newTestTask("c")
+	c.Command.EstimatedRuntime = 100 * time.Second
+
+	dag, err := buildTaskDAG([]*Task{a, b, c})
+	if err != nil {
+		t.Fatalf("unexpected error building dag: %v", err)
+	}
+
+	// the longest chain is the standalone c (100s), not a -> b (15s), since
+	// criticalPathSeconds walks every task, not only those with edges.
+	if got := dag.criticalPathSeconds(); got != 100 {
+		t.Fatalf("expected critical path of 100s (from standalone c), got %v", got)
+	}
+}
+
+func names(tasks []*Task) []string {
+	out := make([]string, len(tasks))
+	for i, t := range tasks {
+		out[i] = t.Name
+	}
+	return out
+}
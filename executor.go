@@ -0,0 +1,337 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"sync"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/stdcopy"
+	"golang.org/x/crypto/ssh"
+)
+
+// Waiter abstracts over the different ways an Executor's underlying process
+// can be waited on for completion and have its exit code read back.
+type Waiter interface {
+	Wait() error
+	ExitCode() int
+}
+
+// Executor starts a task's command on some backend -- the local machine, a
+// remote host over SSH, or inside a Docker container -- and hands back
+// pipes for its stdout/stderr plus a Waiter for its completion. Task.run
+// drives any Executor identically, so fanning a task list out across a
+// fleet of hosts or ephemeral containers is a matter of the task's
+// `executor:` block rather than a different code path.
+type Executor interface {
+	Start(task *Task) (stdout io.ReadCloser, stderr io.ReadCloser, waiter Waiter, err error)
+}
+
+// ExecutorSpec is the `executor:` block on a task, selecting and
+// configuring one of the supported backends. The zero value resolves to
+// LocalExecutor, preserving existing behavior for tasks that don't set it.
+type ExecutorSpec struct {
+	Type    string   `yaml:"type"`
+	Host    string   `yaml:"host"`
+	User    string   `yaml:"user"`
+	Key     string   `yaml:"key"`
+	Image   string   `yaml:"image"`
+	Volumes []string `yaml:"volumes"`
+}
+
+// Build resolves the spec into a concrete Executor.
+func (spec ExecutorSpec) Build() (Executor, error) {
+	switch spec.Type {
+	case "", "local":
+		return LocalExecutor{}, nil
+	case "ssh":
+		return &SSHExecutor{Host: spec.Host, User: spec.User, KeyPath: spec.Key}, nil
+	case "docker":
+		return &DockerExecutor{Image: spec.Image, Volumes: spec.Volumes}, nil
+	default:
+		return nil, fmt.Errorf("unknown executor type %q", spec.Type)
+	}
+}
+
+// LocalExecutor runs a task's command on the local machine through a shell
+// (see shellInvocation), so that pipes, redirects, quoting, env expansion, and globs
+// behave the way the user expects instead of being torn apart by a naive
+// whitespace split.
+type LocalExecutor struct{}
+
+// Start implements Executor.
+func (LocalExecutor) Start(task *Task) (io.ReadCloser, io.ReadCloser, Waiter, error) {
+	shellPath, shellFlag := task.shellInvocation()
+	cmd := exec.Command(shellPath, shellFlag, task.CmdString)
+	cmd.Dir = task.WorkingDir
+	if len(task.Env) > 0 {
+		cmd.Env = append(os.Environ(), formatEnv(task.Env)...)
+	}
+	task.Command.Cmd = cmd
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, nil, nil, err
+	}
+	return stdout, stderr, &localWaiter{cmd: cmd}, nil
+}
+
+// shellInvocation resolves the shell binary and "run a command string" flag
+// used to execute a task's CmdString, honoring a per-task `shell:` override
+// and otherwise falling back to a sane platform default.
+func (task *Task) shellInvocation() (string, string) {
+	if task.Shell != "" {
+		return task.Shell, "-c"
+	}
+	if runtime.GOOS == "windows" {
+		return "cmd", "/c"
+	}
+	return "sh", "-c"
+}
+
+// formatEnv renders a task's `env:` map as NAME=VALUE pairs suitable for
+// exec.Cmd.Env.
+func formatEnv(env map[string]string) []string {
+	out := make([]string, 0, len(env))
+	for name, value := range env {
+		out = append(out, name+"="+value)
+	}
+	return out
+}
+
+type localWaiter struct{ cmd *exec.Cmd }
+
+func (w *localWaiter) Wait() error { return w.cmd.Wait() }
+
+func (w *localWaiter) ExitCode() int {
+	if w.cmd.ProcessState != nil {
+		return w.cmd.ProcessState.ExitCode()
+	}
+	return -1
+}
+
+// SSHExecutor runs a task's command on a remote host, multiplexing sessions
+// over a single cached connection per host/user pair so that a task list
+// targeting the same fleet member doesn't re-handshake per task.
+type SSHExecutor struct {
+	Host    string
+	User    string
+	KeyPath string
+}
+
+var (
+	sshClientsMu sync.Mutex
+	sshClients   = map[string]*ssh.Client{}
+)
+
+func (exctr *SSHExecutor) client() (*ssh.Client, error) {
+	key := exctr.User + "@" + exctr.Host
+
+	sshClientsMu.Lock()
+	defer sshClientsMu.Unlock()
+
+	if cached, ok := sshClients[key]; ok {
+		return cached, nil
+	}
+
+	signer, err := loadSSHSigner(exctr.KeyPath)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &ssh.ClientConfig{
+		User:            exctr.User,
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	}
+
+	addr := exctr.Host
+	if !strings.Contains(addr, ":") {
+		addr = net.JoinHostPort(addr, "22")
+	}
+
+	dialed, err := ssh.Dial("tcp", addr, config)
+	if err != nil {
+		return nil, err
+	}
+
+	sshClients[key] = dialed
+	return dialed, nil
+}
+
+// Start implements Executor.
+func (exctr *SSHExecutor) Start(task *Task) (io.ReadCloser, io.ReadCloser, Waiter, error) {
+	sshClient, err := exctr.client()
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("ssh dial %s: %w", exctr.Host, err)
+	}
+
+	session, err := sshClient.NewSession()
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("ssh session %s: %w", exctr.Host, err)
+	}
+
+	// best effort: most sshd configs reject SetEnv for anything not in
+	// AcceptEnv, but there's no way to detect that up front, so a task's
+	// `env:` is still honored for servers that do allow it.
+	for name, value := range task.Env {
+		_ = session.Setenv(name, value)
+	}
+
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	stderr, err := session.StderrPipe()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if err := session.Start(remoteCommand(task)); err != nil {
+		return nil, nil, nil, err
+	}
+
+	return ioutil.NopCloser(stdout), ioutil.NopCloser(stderr), &sshWaiter{session: session}, nil
+}
+
+// remoteCommand builds the command line sent to the remote shell, honoring a
+// task's WorkingDir and Shell -- config LocalExecutor applies by setting
+// cmd.Dir and invoking task.Shell directly, neither of which has an
+// equivalent on an SSH "exec" request.
+func remoteCommand(task *Task) string {
+	cmdString := task.CmdString
+	if task.WorkingDir != "" {
+		cmdString = "cd " + shellQuote(task.WorkingDir) + " && " + cmdString
+	}
+	if task.Shell != "" {
+		cmdString = task.Shell + " -c " + shellQuote(cmdString)
+	}
+	return cmdString
+}
+
+// shellQuote wraps s in single quotes, escaping any single quotes already in
+// it, so it survives as one argument through a POSIX shell.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+type sshWaiter struct {
+	session  *ssh.Session
+	exitCode int
+}
+
+func (w *sshWaiter) Wait() error {
+	err := w.session.Wait()
+	defer w.session.Close()
+
+	w.exitCode = 0
+	switch exitErr := err.(type) {
+	case *ssh.ExitError:
+		w.exitCode = exitErr.ExitStatus()
+	default:
+		if err != nil {
+			w.exitCode = -1
+		}
+	}
+	return err
+}
+
+func (w *sshWaiter) ExitCode() int { return w.exitCode }
+
+// loadSSHSigner reads and parses a private key used for SSH authentication.
+func loadSSHSigner(path string) (ssh.Signer, error) {
+	key, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ssh.ParsePrivateKey(key)
+}
+
+// DockerExecutor runs a task's command inside an ephemeral container, for
+// fanning a task list out across per-task isolated environments.
+type DockerExecutor struct {
+	Image   string
+	Volumes []string
+}
+
+// Start implements Executor.
+func (exctr *DockerExecutor) Start(task *Task) (io.ReadCloser, io.ReadCloser, Waiter, error) {
+	ctx := context.Background()
+
+	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("docker client: %w", err)
+	}
+
+	shell := "sh"
+	if task.Shell != "" {
+		shell = task.Shell
+	}
+
+	created, err := cli.ContainerCreate(ctx,
+		&container.Config{
+			Image:      exctr.Image,
+			Cmd:        []string{shell, "-c", task.CmdString},
+			WorkingDir: task.WorkingDir,
+			Env:        formatEnv(task.Env),
+		},
+		&container.HostConfig{Binds: exctr.Volumes},
+		nil, nil, "")
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("docker create %s: %w", exctr.Image, err)
+	}
+
+	attach, err := cli.ContainerAttach(ctx, created.ID, container.AttachOptions{Stream: true, Stdout: true, Stderr: true})
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	if err := cli.ContainerStart(ctx, created.ID, container.StartOptions{}); err != nil {
+		return nil, nil, nil, err
+	}
+
+	stdoutR, stdoutW := io.Pipe()
+	stderrR, stderrW := io.Pipe()
+	go func() {
+		defer attach.Close()
+		defer stdoutW.Close()
+		defer stderrW.Close()
+		stdcopy.StdCopy(stdoutW, stderrW, attach.Reader)
+	}()
+
+	return stdoutR, stderrR, &dockerWaiter{ctx: ctx, cli: cli, containerID: created.ID}, nil
+}
+
+type dockerWaiter struct {
+	ctx         context.Context
+	cli         *client.Client
+	containerID string
+	exitCode    int
+}
+
+func (w *dockerWaiter) Wait() error {
+	statusCh, errCh := w.cli.ContainerWait(w.ctx, w.containerID, container.WaitConditionNotRunning)
+	select {
+	case err := <-errCh:
+		return err
+	case status := <-statusCh:
+		w.exitCode = int(status.StatusCode)
+	}
+	return w.cli.ContainerRemove(w.ctx, w.containerID, container.RemoveOptions{})
+}
+
+func (w *dockerWaiter) ExitCode() int { return w.exitCode }
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func newTestExecutorTask(cmd string) *Task {
+	return &Task{CmdString: cmd, Command: TaskCommand{Status: StatusPending}}
+}
+
+func TestLocalExecutorHonorsWorkingDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bashful-executor-test")
+	if err != nil {
+		t.Fatalf("unexpected error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	task := newTestExecutorTask("pwd")
+	task.WorkingDir = dir
+
+	stdout, stderr, waiter, err := (LocalExecutor{}).Start(task)
+	if err != nil {
+		t.Fatalf("unexpected error starting task: %v", err)
+	}
+	out, _ := ioutil.ReadAll(stdout)
+	ioutil.ReadAll(stderr)
+	if err := waiter.Wait(); err != nil {
+		t.Fatalf("unexpected error waiting for task: %v", err)
+	}
+
+	if got := strings.TrimSpace(string(out)); got != dir {
+		t.Fatalf("pwd ran in %q, want %q", got, dir)
+	}
+}
+
+func TestLocalExecutorHonorsEnv(t *testing.T) {
+	task := newTestExecutorTask("echo $BASHFUL_TEST_VAR")
+	task.Env = map[string]string{"BASHFUL_TEST_VAR": "hello"}
+
+	stdout, stderr, waiter, err := (LocalExecutor{}).Start(task)
+	if err != nil {
+		t.Fatalf("unexpected error starting task: %v", err)
+	}
+	out, _ := ioutil.ReadAll(stdout)
+	ioutil.ReadAll(stderr)
+	if err := waiter.Wait(); err != nil {
+		t.Fatalf("unexpected error waiting for task: %v", err)
+	}
+
+	if got := strings.TrimSpace(string(out)); got != "hello" {
+		t.Fatalf("got output %q, want %q", got, "hello")
+	}
+}
+
+func TestLocalExecutorHonorsShellOverride(t *testing.T) {
+	task := newTestExecutorTask("echo shell=$0")
+	task.Shell = "/bin/sh"
+
+	shellPath, shellFlag := task.shellInvocation()
+	if shellPath != "/bin/sh" || shellFlag != "-c" {
+		t.Fatalf("shellInvocation() = (%q, %q), want (%q, %q)", shellPath, shellFlag, "/bin/sh", "-c")
+	}
+}
+
+func TestRemoteCommandWrapsWorkingDirAndShell(t *testing.T) {
+	task := newTestExecutorTask("make all")
+	task.WorkingDir = "/srv/app"
+	task.Shell = "bash"
+
+	got := remoteCommand(task)
+	want := `bash -c 'cd '\''/srv/app'\'' && make all'`
+	if got != want {
+		t.Fatalf("remoteCommand() = %q, want %q", got, want)
+	}
+}
+
+func TestRemoteCommandPlainWithoutOverrides(t *testing.T) {
+	task := newTestExecutorTask("make all")
+
+	if got := remoteCommand(task); got != "make all" {
+		t.Fatalf("remoteCommand() = %q, want %q", got, "make all")
+	}
+}
+
+func TestShellQuoteEscapesSingleQuotes(t *testing.T) {
+	cases := []struct {
+		in   string
+		want string
+	}{
+		{in: "plain", want: "'plain'"},
+		{in: "has'quote", want: `'has'\''quote'`},
+	}
+	for _, c := range cases {
+		if got := shellQuote(c.in); got != c.want {
+			t.Fatalf("shellQuote(%q) = %q, want %q", c.in, got, c.want)
+		}
+	}
+}
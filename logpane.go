@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	ansi "github.com/k0kubun/go-ansi"
+)
+
+const defaultLogPaneLines = 5
+
+// logViewerPageSize is how many lines LogViewer shows, and scrolls by, per
+// [f]orward/[b]ack command.
+const logViewerPageSize = defaultLogPaneLines * 4
+
+// tailBuffer keeps the most recently appended lines, bounded to a fixed
+// capacity, for rendering a task's live output beneath its status row.
+type tailBuffer struct {
+	lines []string
+	cap   int
+}
+
+// newTailBuffer builds a tailBuffer holding at most capacity lines. A
+// capacity <= 0 falls back to defaultLogPaneLines so a task always has a
+// usable buffer, even if Options.LogPane ends up enabled after Create runs.
+func newTailBuffer(capacity int) *tailBuffer {
+	if capacity <= 0 {
+		capacity = defaultLogPaneLines
+	}
+	return &tailBuffer{cap: capacity}
+}
+
+// Append records a line, dropping the oldest once the buffer is full.
+func (tb *tailBuffer) Append(line string) {
+	if tb == nil {
+		return
+	}
+	tb.lines = append(tb.lines, line)
+	if len(tb.lines) > tb.cap {
+		tb.lines = tb.lines[len(tb.lines)-tb.cap:]
+	}
+}
+
+// Lines returns the buffered lines, oldest first.
+func (tb *tailBuffer) Lines() []string {
+	if tb == nil {
+		return nil
+	}
+	return tb.lines
+}
+
+// paneLines returns how many tail-buffer rows should currently be rendered
+// beneath this task's status row.
+func (task *Task) paneLines() int {
+	if Options.LogPane != "bottom" {
+		return 0
+	}
+	return len(task.Tail.Lines())
+}
+
+// displayWithTail renders the task's status row and, when Options.LogPane
+// is "bottom", its live tail-buffer pane directly beneath it, advancing
+// curLine by however many rows were written -- the same bookkeeping used
+// for every other cursor move in Task.Process.
+func (task *Task) displayWithTail(curLine *int) {
+	task.display(curLine)
+
+	for _, line := range task.Tail.Lines() {
+		if Options.LogPane != "bottom" {
+			break
+		}
+		ansi.EraseInLine(2)
+		fmt.Println("    " + line)
+		*curLine++
+	}
+}
+
+// logViewerEntry is a single browsable task log in the post-run viewer.
+type logViewerEntry struct {
+	Name string
+	Path string
+}
+
+// LogViewer lets a user step through each task's captured log with typed
+// [n]ext/[p]rev commands (arrow keys are not read) and page and search
+// through it, reading the per-task temp files Task.run creates
+// via ioutil.TempFile(logCachePath, ...). It is invoked with --log-viewer or
+// automatically on failure.
+type LogViewer struct {
+	entries []logViewerEntry
+	task    int
+	line    int
+	query   string
+	in      *bufio.Reader
+	out     *os.File
+}
+
+// NewLogViewer builds a viewer over every task that produced a log file.
+func NewLogViewer(tasks []*Task) *LogViewer {
+	var entries []logViewerEntry
+	for _, t := range tasks {
+		if t.LogFile == nil {
+			continue
+		}
+		entries = append(entries, logViewerEntry{Name: t.Name, Path: t.LogFile.Name()})
+	}
+	return &LogViewer{entries: entries, in: bufio.NewReader(os.Stdin), out: os.Stdout}
+}
+
+// Run drives the interactive viewer until the user quits. It is line-based
+// rather than raw-terminal to keep to this package's existing dependency
+// set (ansi cursor control plus buffered stdin), at the cost of needing
+// Enter after each keystroke.
+func (viewer *LogViewer) Run() error {
+	if len(viewer.entries) == 0 {
+		fmt.Fprintln(viewer.out, "no task logs to view")
+		return nil
+	}
+
+	for {
+		lines, err := viewer.currentLines()
+		if err != nil {
+			return err
+		}
+		viewer.render(lines)
+
+		fmt.Fprint(viewer.out, "\n[n]ext task, [p]rev task, [f]orward, [b]ack, [/]search, [q]uit > ")
+		input, err := viewer.in.ReadString('\n')
+		if err != nil {
+			// stdin closed (EOF) -- the non-interactive/CI invocation path --
+			// there's no more input to prompt for, so quit instead of looping
+			// on an empty read forever.
+			return nil
+		}
+		switch strings.TrimSpace(input) {
+		case "n":
+			viewer.task = (viewer.task + 1) % len(viewer.entries)
+			viewer.line = 0
+		case "p":
+			viewer.task = (viewer.task - 1 + len(viewer.entries)) % len(viewer.entries)
+			viewer.line = 0
+		case "f":
+			if next := viewer.line + logViewerPageSize; next < len(lines) {
+				viewer.line = next
+			}
+		case "b":
+			viewer.line -= logViewerPageSize
+			if viewer.line < 0 {
+				viewer.line = 0
+			}
+		case "q":
+			return nil
+		case "":
+		default:
+			if strings.HasPrefix(strings.TrimSpace(input), "/") {
+				viewer.query = strings.TrimPrefix(strings.TrimSpace(input), "/")
+				if idx := viewer.search(lines, viewer.query, viewer.line+1); idx >= 0 {
+					viewer.line = idx
+				}
+			}
+		}
+	}
+}
+
+func (viewer *LogViewer) currentLines() ([]string, error) {
+	entry := viewer.entries[viewer.task]
+	raw, err := ioutil.ReadFile(entry.Path)
+	if err != nil {
+		return nil, err
+	}
+	return strings.Split(string(raw), "\n"), nil
+}
+
+// search returns the index of the first line at or after from containing
+// query, or -1 if there is no match.
+func (viewer *LogViewer) search(lines []string, query string, from int) int {
+	if query == "" {
+		return -1
+	}
+	for idx := from; idx < len(lines); idx++ {
+		if strings.Contains(lines[idx], query) {
+			return idx
+		}
+	}
+	return -1
+}
+
+func (viewer *LogViewer) render(lines []string) {
+	entry := viewer.entries[viewer.task]
+	fmt.Fprintf(viewer.out, "\n=== %s (%d/%d) ===\n", entry.Name, viewer.task+1, len(viewer.entries))
+
+	start := viewer.line
+	if start < 0 || start >= len(lines) {
+		start = 0
+	}
+	end := start + logViewerPageSize
+	if end > len(lines) {
+		end = len(lines)
+	}
+	for _, line := range lines[start:end] {
+		fmt.Fprintln(viewer.out, line)
+	}
+}
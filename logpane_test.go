@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bufio"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestLogViewer(t *testing.T, input string, lines ...string) *LogViewer {
+	t.Helper()
+
+	f, err := ioutil.TempFile("", "bashful-logpane-test")
+	if err != nil {
+		t.Fatalf("unexpected error creating temp log file: %v", err)
+	}
+	t.Cleanup(func() { os.Remove(f.Name()) })
+
+	if _, err := f.WriteString(strings.Join(lines, "\n")); err != nil {
+		t.Fatalf("unexpected error writing temp log file: %v", err)
+	}
+	f.Close()
+
+	devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatalf("unexpected error opening %s: %v", os.DevNull, err)
+	}
+	t.Cleanup(func() { devNull.Close() })
+
+	return &LogViewer{
+		entries: []logViewerEntry{{Name: "build", Path: f.Name()}},
+		in:      bufio.NewReader(strings.NewReader(input)),
+		out:     devNull,
+	}
+}
+
+func TestLogViewerQuitsOnStdinEOF(t *testing.T) {
+	// no trailing "q\n" -- just EOF, the shape of a non-interactive/CI
+	// invocation with nothing left on stdin to read. Before the fix this
+	// spun forever re-prompting on the empty read ReadString returns
+	// alongside io.EOF.
+	viewer := newTestLogViewer(t, "", "line one", "line two")
+
+	done := make(chan error, 1)
+	go func() { done <- viewer.Run() }()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("unexpected error from Run: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Run did not return after stdin hit EOF")
+	}
+}
+
+func TestLogViewerQuitsOnQ(t *testing.T) {
+	viewer := newTestLogViewer(t, "q\n", "line one", "line two")
+
+	if err := viewer.Run(); err != nil {
+		t.Fatalf("unexpected error from Run: %v", err)
+	}
+}
+
+func TestLogViewerSearchFindsFirstMatchAtOrAfter(t *testing.T) {
+	lines := []string{"alpha", "beta needle", "gamma", "delta needle"}
+	viewer := &LogViewer{}
+
+	if idx := viewer.search(lines, "needle", 0); idx != 1 {
+		t.Fatalf("search from 0 = %d, want 1", idx)
+	}
+	if idx := viewer.search(lines, "needle", 2); idx != 3 {
+		t.Fatalf("search from 2 = %d, want 3", idx)
+	}
+	if idx := viewer.search(lines, "nope", 0); idx != -1 {
+		t.Fatalf("search for absent query = %d, want -1", idx)
+	}
+	if idx := viewer.search(lines, "", 0); idx != -1 {
+		t.Fatalf("search with empty query = %d, want -1", idx)
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ProgressEventType identifies the kind of update carried by a ProgressEvent,
+// mirroring the shape of buildkit's SolveStatus vertex/log/status events.
+type ProgressEventType int
+
+const (
+	VertexStarted ProgressEventType = iota
+	VertexStatus
+	VertexLog
+	VertexCompleted
+)
+
+// ProgressEvent is a single, renderer-agnostic update about a task's
+// lifecycle. Task.run publishes these onto a ProgressBus, in addition to
+// (not instead of) the CmdIR values it sends on Process's own resultChan --
+// CmdIR remains the scheduling signal Process drives off of; the bus exists
+// so other observers, like the terminal renderer, can watch the same
+// lifecycle without task.go knowing about them.
+type ProgressEvent struct {
+	Type       ProgressEventType
+	Vertex     string
+	Status     CommandStatus
+	Stream     string // "stdout" or "stderr", set on VertexLog
+	Line       string // set on VertexLog
+	Partial    bool   // marks a VertexLog line that is not newline-terminated; Task.run never sets it
+	ReturnCode int    // set on VertexCompleted
+	Duration   time.Duration
+	Time       time.Time
+}
+
+// ProgressSubscriber receives every event published to a ProgressBus.
+type ProgressSubscriber interface {
+	Handle(ProgressEvent)
+}
+
+// ProgressBus fans out ProgressEvents to any number of subscribers. Task.run
+// is the sole publisher; anything else interested in task progress --
+// besides Process itself, which still schedules off CmdIR -- subscribes
+// rather than reading CmdIR directly.
+type ProgressBus struct {
+	events      chan ProgressEvent
+	mu          sync.RWMutex
+	subscribers []ProgressSubscriber
+	dropped     uint64
+}
+
+// NewProgressBus creates a bus with a buffered event channel large enough to
+// absorb bursty task output without blocking Task.run.
+func NewProgressBus() *ProgressBus {
+	return &ProgressBus{events: make(chan ProgressEvent, 10000)}
+}
+
+// Subscribe registers a subscriber to receive all future published events.
+func (bus *ProgressBus) Subscribe(sub ProgressSubscriber) {
+	bus.mu.Lock()
+	defer bus.mu.Unlock()
+	bus.subscribers = append(bus.subscribers, sub)
+}
+
+// Publish enqueues an event for dispatch. It never blocks the caller: if the
+// buffer is full (Run isn't keeping up, or nothing has started it yet), the
+// event is dropped and counted rather than stalling the publishing task.
+func (bus *ProgressBus) Publish(evt ProgressEvent) {
+	select {
+	case bus.events <- evt:
+	default:
+		atomic.AddUint64(&bus.dropped, 1)
+	}
+}
+
+// Dropped returns the number of events discarded so far because the buffer
+// was full.
+func (bus *ProgressBus) Dropped() uint64 {
+	return atomic.LoadUint64(&bus.dropped)
+}
+
+// Run dispatches events to all subscribers until done is closed. It is
+// intended to run in its own goroutine for the lifetime of a bashful
+// invocation.
+func (bus *ProgressBus) Run(done <-chan struct{}) {
+	for {
+		select {
+		case evt := <-bus.events:
+			bus.mu.RLock()
+			for _, sub := range bus.subscribers {
+				sub.Handle(evt)
+			}
+			bus.mu.RUnlock()
+		case <-done:
+			return
+		}
+	}
+}
+
+// progressBus is the process-wide event stream that Task.run publishes to.
+var progressBus = NewProgressBus()
+
+var startProgressBusOnce sync.Once
+
+// startProgressBus subscribes the terminal renderer and starts dispatching
+// the bus for the lifetime of the process. Task.Process calls this before
+// scheduling any tasks, so the bus always has a consumer before Task.run's
+// first Publish.
+func startProgressBus() {
+	startProgressBusOnce.Do(func() {
+		progressBus.Subscribe(terminalProgressSubscriber{})
+		go progressBus.Run(make(chan struct{}))
+	})
+}
+
+// terminalProgressSubscriber forwards progress events into the existing
+// mainLogChan-based logging so the ANSI terminal renderer remains a
+// subscriber of the bus rather than a hard-coded dependency of Task.run.
+// The detailed, cursor-addressed rendering continues to live in
+// Task.Process, which is itself driven by the same underlying task events.
+type terminalProgressSubscriber struct{}
+
+func (terminalProgressSubscriber) Handle(evt ProgressEvent) {
+	switch evt.Type {
+	case VertexStarted:
+		mainLogChan <- LogItem{Name: evt.Vertex, Message: boldyellow("Started Task: " + evt.Vertex)}
+	case VertexCompleted:
+		mainLogChan <- LogItem{Name: evt.Vertex, Message: boldyellow(fmt.Sprintf("Completed Task: %s (rc: %d)", evt.Vertex, evt.ReturnCode))}
+	}
+}
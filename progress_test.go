@@ -0,0 +1,51 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+type funcSubscriber func(ProgressEvent)
+
+func (f funcSubscriber) Handle(evt ProgressEvent) { f(evt) }
+
+func TestProgressBusDispatchesToSubscribers(t *testing.T) {
+	bus := NewProgressBus()
+	received := make(chan ProgressEvent, 1)
+	bus.Subscribe(funcSubscriber(func(evt ProgressEvent) { received <- evt }))
+
+	done := make(chan struct{})
+	go bus.Run(done)
+	defer close(done)
+
+	bus.Publish(ProgressEvent{Type: VertexStarted, Vertex: "build"})
+
+	select {
+	case evt := <-received:
+		if evt.Vertex != "build" {
+			t.Fatalf("got vertex %q, want %q", evt.Vertex, "build")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("subscriber never received the published event")
+	}
+}
+
+func TestProgressBusDropsRatherThanBlocksWhenUnconsumed(t *testing.T) {
+	bus := NewProgressBus()
+	// nothing ever subscribes or calls Run -- publishing past the buffer
+	// must count drops instead of blocking the caller forever.
+	capacity := cap(bus.events)
+	for i := 0; i < capacity; i++ {
+		bus.Publish(ProgressEvent{Type: VertexStarted})
+	}
+	if got := bus.Dropped(); got != 0 {
+		t.Fatalf("expected no drops while under capacity, got %d", got)
+	}
+
+	bus.Publish(ProgressEvent{Type: VertexStarted})
+	bus.Publish(ProgressEvent{Type: VertexStarted})
+
+	if got := bus.Dropped(); got != 2 {
+		t.Fatalf("expected 2 drops once the buffer filled, got %d", got)
+	}
+}
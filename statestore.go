@@ -0,0 +1,184 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"time"
+)
+
+// TaskState is the persisted record for a single task, keyed by task name
+// in a StateStore. CmdHash lets a resumed run tell a cached success apart
+// from a task whose command changed since the state file was written.
+type TaskState struct {
+	Name             string        `json:"name"`
+	CmdString        string        `json:"cmd_string"`
+	CmdHash          string        `json:"cmd_hash"`
+	Status           CommandStatus `json:"status"`
+	ReturnCode       int           `json:"return_code"`
+	StdoutDigest     string        `json:"stdout_digest"`
+	StderrDigest     string        `json:"stderr_digest"`
+	StartTime        time.Time     `json:"start_time"`
+	StopTime         time.Time     `json:"stop_time"`
+	EstimatedRuntime time.Duration `json:"estimated_runtime"`
+	DependsOn        []string      `json:"depends_on,omitempty"`
+}
+
+// StateStore persists per-task results across invocations, as a single JSON
+// document, so that a `--resume` run can skip tasks that already succeeded
+// and `bashful status <state-file>` can summarize a prior run.
+type StateStore struct {
+	Path  string               `json:"-"`
+	Tasks map[string]TaskState `json:"tasks"`
+}
+
+// LoadStateStore reads a state file, returning an empty store (rather than
+// an error) when the file doesn't exist yet, so the first `--state-file`
+// run of a task list just starts writing one.
+func LoadStateStore(path string) (*StateStore, error) {
+	store := &StateStore{Path: path, Tasks: make(map[string]TaskState)}
+
+	raw, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(raw, store); err != nil {
+		return nil, fmt.Errorf("parsing state file %s: %w", path, err)
+	}
+	store.Path = path
+	if store.Tasks == nil {
+		store.Tasks = make(map[string]TaskState)
+	}
+	return store, nil
+}
+
+// Save writes the store back out as JSON, via a temp file + rename so a
+// crash mid-write can't corrupt the previous state.
+func (store *StateStore) Save() error {
+	raw, err := json.MarshalIndent(store, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := store.Path + ".tmp"
+	if err := ioutil.WriteFile(tmp, raw, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, store.Path)
+}
+
+// Get looks up a task's last recorded state.
+func (store *StateStore) Get(name string) (TaskState, bool) {
+	state, ok := store.Tasks[name]
+	return state, ok
+}
+
+// Invalidate removes a task's recorded state, used to implement
+// `--force <taskname>` so a resumed run re-executes it regardless of cache.
+func (store *StateStore) Invalidate(name string) {
+	delete(store.Tasks, name)
+}
+
+// Record stores a completed task's outcome, replacing any prior entry.
+func (store *StateStore) Record(task *Task) {
+	store.Tasks[task.Name] = TaskState{
+		Name:             task.Name,
+		CmdString:        task.CmdString,
+		CmdHash:          hashCmd(task),
+		Status:           task.Command.Status,
+		ReturnCode:       task.Command.ReturnCode,
+		StdoutDigest:     digest(task.OutputBuffer.String()),
+		StderrDigest:     digest(task.ErrorBuffer.String()),
+		StartTime:        task.Command.StartTime,
+		StopTime:         task.Command.StopTime,
+		EstimatedRuntime: task.Command.StopTime.Sub(task.Command.StartTime),
+		DependsOn:        task.DependsOn,
+	}
+}
+
+// Reusable reports whether a task's last recorded run can be reused as-is:
+// it succeeded (or was skipped) and its command hasn't changed since.
+func (store *StateStore) Reusable(task *Task) bool {
+	cached, ok := store.Get(task.Name)
+	if !ok {
+		return false
+	}
+	if cached.CmdHash != hashCmd(task) {
+		return false
+	}
+	return cached.Status == StatusSuccess || cached.Status == StatusSkipped
+}
+
+// ApplyCommandTimeCache folds every recorded runtime into commandTimeCache so
+// ETAs computed via Task.EstimatedRuntime benefit from prior invocations,
+// not just the tasks run this time. It's keyed by CmdString, matching every
+// other reader/writer of commandTimeCache -- not by task name, which can
+// differ from the command it runs.
+func (store *StateStore) ApplyCommandTimeCache() {
+	for _, state := range store.Tasks {
+		if state.EstimatedRuntime > 0 && state.CmdString != "" {
+			commandTimeCache[state.CmdString] = state.EstimatedRuntime
+		}
+	}
+}
+
+// hashCmd fingerprints a task's CmdString, WorkingDir, Shell and Env, so a
+// resumed run only trusts a cached result for an identical command. The
+// task's executor block is not part of the hash.
+func hashCmd(task *Task) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%s\x00%s\x00%v", task.CmdString, task.WorkingDir, task.Shell, task.Env)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// digest summarizes captured output for inclusion in a state record.
+func digest(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+// RunStatusCommand implements `bashful status <state-file>`: it loads a
+// state file and prints a summary table of every recorded task.
+func RunStatusCommand(path string) error {
+	store, err := LoadStateStore(path)
+	if err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(store.Tasks))
+	for name := range store.Tasks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Printf("%-30s %-10s %-6s %s\n", "TASK", "STATUS", "RC", "DURATION")
+	for _, name := range names {
+		state := store.Tasks[name]
+		duration := state.StopTime.Sub(state.StartTime)
+		fmt.Printf("%-30s %-10s %-6d %s\n", state.Name, statusLabel(state.Status), state.ReturnCode, duration)
+	}
+	return nil
+}
+
+func statusLabel(status CommandStatus) string {
+	switch status {
+	case StatusSuccess:
+		return "success"
+	case StatusError:
+		return "error"
+	case StatusSkipped:
+		return "skipped"
+	case StatusRunning:
+		return "running"
+	default:
+		return "pending"
+	}
+}
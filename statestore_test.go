@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func newTestTaskForState(name, cmd string) *Task {
+	return &Task{
+		Name:         name,
+		CmdString:    cmd,
+		Command:      TaskCommand{Status: StatusPending},
+		OutputBuffer: bytes.NewBufferString(""),
+		ErrorBuffer:  bytes.NewBufferString(""),
+	}
+}
+
+func TestHashCmdChangesWithCommandConfig(t *testing.T) {
+	base := newTestTaskForState("build", "make all")
+
+	same := newTestTaskForState("build", "make all")
+	if hashCmd(base) != hashCmd(same) {
+		t.Fatal("two tasks with identical cmd/workdir/shell/env should hash the same")
+	}
+
+	differentCmd := newTestTaskForState("build", "make clean")
+	if hashCmd(base) == hashCmd(differentCmd) {
+		t.Fatal("a changed CmdString should change the hash")
+	}
+
+	differentEnv := newTestTaskForState("build", "make all")
+	differentEnv.Env = map[string]string{"FOO": "bar"}
+	if hashCmd(base) == hashCmd(differentEnv) {
+		t.Fatal("a changed Env should change the hash")
+	}
+
+	differentDir := newTestTaskForState("build", "make all")
+	differentDir.WorkingDir = "/tmp"
+	if hashCmd(base) == hashCmd(differentDir) {
+		t.Fatal("a changed WorkingDir should change the hash")
+	}
+}
+
+func TestStateStoreReusable(t *testing.T) {
+	store := &StateStore{Tasks: make(map[string]TaskState)}
+	task := newTestTaskForState("build", "make all")
+
+	if store.Reusable(task) {
+		t.Fatal("a task with no recorded state should not be reusable")
+	}
+
+	task.Command.Status = StatusSuccess
+	store.Record(task)
+
+	if !store.Reusable(task) {
+		t.Fatal("an unchanged, successful task should be reusable")
+	}
+
+	task.CmdString = "make clean"
+	if store.Reusable(task) {
+		t.Fatal("a task whose command changed since it was recorded should not be reusable")
+	}
+
+	task.CmdString = "make all"
+	task.Command.Status = StatusError
+	store.Record(task)
+	if store.Reusable(task) {
+		t.Fatal("a failed task should not be reusable")
+	}
+}
+
+func TestApplyCommandTimeCacheKeyedByCmdString(t *testing.T) {
+	store := &StateStore{Tasks: map[string]TaskState{
+		"build": {Name: "build", CmdString: "make all", EstimatedRuntime: 42 * time.Second},
+	}}
+
+	commandTimeCache = make(map[string]time.Duration)
+	store.ApplyCommandTimeCache()
+
+	if _, ok := commandTimeCache["build"]; ok {
+		t.Fatal("commandTimeCache should be keyed by CmdString, not task name")
+	}
+	if got, ok := commandTimeCache["make all"]; !ok || got != 42*time.Second {
+		t.Fatalf("expected commandTimeCache[%q] == 42s, got %v (ok=%v)", "make all", got, ok)
+	}
+}
@@ -13,13 +13,12 @@ import (
 	"strconv"
 	"strings"
 	"sync"
-	"syscall"
 	"time"
 
 	ansi "github.com/k0kubun/go-ansi"
 	"github.com/lunixbochs/vtclean"
-	spin "github.com/tj/go-spin"
 	color "github.com/mgutz/ansi"
+	spin "github.com/tj/go-spin"
 	terminal "github.com/wayneashleyberry/terminal-dimensions"
 )
 
@@ -28,14 +27,24 @@ type Task struct {
 	CmdString      string `yaml:"cmd"`
 	Display        TaskDisplay
 	Command        TaskCommand
-	StopOnFailure  bool     `yaml:"stop-on-failure"`
-	ShowTaskOutput bool     `yaml:"show-output"`
-	IgnoreFailure  bool     `yaml:"ignore-failure"`
-	ParallelTasks  []Task   `yaml:"parallel-tasks"`
-	ForEach        []string `yaml:"for-each"`
+	StopOnFailure  bool              `yaml:"stop-on-failure"`
+	ShowTaskOutput bool              `yaml:"show-output"`
+	IgnoreFailure  bool              `yaml:"ignore-failure"`
+	ParallelTasks  []Task            `yaml:"parallel-tasks"`
+	ForEach        []string          `yaml:"for-each"`
+	DependsOn      []string          `yaml:"depends-on"`
+	Retries        int               `yaml:"retries"`
+	RetryDelay     time.Duration     `yaml:"retry-delay"`
+	When           string            `yaml:"when"`
+	ExecutorConfig ExecutorSpec      `yaml:"executor"`
+	Env            map[string]string `yaml:"env"`
+	WorkingDir     string            `yaml:"working-dir"`
+	Shell          string            `yaml:"shell"`
 	LogChan        chan LogItem
 	LogFile        *os.File
 	ErrorBuffer    *bytes.Buffer
+	OutputBuffer   *bytes.Buffer
+	Tail           *tailBuffer
 }
 
 type TaskDisplay struct {
@@ -52,6 +61,8 @@ type TaskCommand struct {
 	Started          bool
 	Complete         bool
 	ReturnCode       int
+	Status           CommandStatus
+	Attempt          int
 }
 
 type CommandStatus int32
@@ -61,21 +72,25 @@ const (
 	StatusPending
 	StatusSuccess
 	StatusError
+	StatusSkipped
 )
 
 func (status CommandStatus) Color(attributes string) string {
 	switch status {
 	case StatusRunning:
-		return color.ColorCode("28+"+attributes)
+		return color.ColorCode("28+" + attributes)
 
 	case StatusPending:
-		return color.ColorCode("22+"+attributes)
+		return color.ColorCode("22+" + attributes)
 
 	case StatusSuccess:
-		return color.ColorCode("green+h"+attributes)
+		return color.ColorCode("green+h" + attributes)
 
 	case StatusError:
-		return color.ColorCode("red+h"+attributes)
+		return color.ColorCode("red+h" + attributes)
+
+	case StatusSkipped:
+		return color.ColorCode("244+" + attributes)
 
 	}
 	return "INVALID COMMAND STATUS"
@@ -173,12 +188,13 @@ func (task *Task) inflate(displayIdx int, replicaValue string) {
 		task.Command.EstimatedRuntime = time.Duration(-1)
 	}
 
-	command := strings.Split(cmdString, " ")
-	task.Command.Cmd = exec.Command(command[0], command[1:]...)
 	task.Command.ReturnCode = -1
+	task.Command.Status = StatusPending
 	task.Display.Template = lineDefaultTemplate
 	task.Display.Index = displayIdx
 	task.ErrorBuffer = bytes.NewBufferString("")
+	task.OutputBuffer = bytes.NewBufferString("")
+	task.Tail = newTailBuffer(Options.LogPaneLines)
 
 	// set the name
 	if name == "" {
@@ -258,6 +274,39 @@ func (task *Task) display(curLine *int) {
 	display(task.String(), curLine, task.Display.Index)
 }
 
+// ansiSequenceEnd returns the index just past a complete ANSI CSI or OSC
+// escape sequence beginning at data[start] (where data[start] == ESC), or -1
+// if the sequence hasn't finished within data yet.
+func ansiSequenceEnd(data []byte, start int) int {
+	if start+1 >= len(data) {
+		return -1
+	}
+
+	switch data[start+1] {
+	case '[': // CSI: ESC [ params... final-byte (0x40-0x7E)
+		for i := start + 2; i < len(data); i++ {
+			if data[i] >= 0x40 && data[i] <= 0x7e {
+				return i + 1
+			}
+		}
+		return -1
+
+	case ']': // OSC: ESC ] ... BEL or ESC \ (ST)
+		for i := start + 2; i < len(data); i++ {
+			if data[i] == 0x07 {
+				return i + 1
+			}
+			if data[i] == 0x1b && i+1 < len(data) && data[i+1] == '\\' {
+				return i + 2
+			}
+		}
+		return -1
+
+	default:
+		// a two-byte escape we don't need to protect the middle of
+		return start + 2
+	}
+}
 
 func variableSplitFunc(data []byte, atEOF bool) (advance int, token []byte, err error) {
 
@@ -266,35 +315,60 @@ func variableSplitFunc(data []byte, atEOF bool) (advance int, token []byte, err
 		return 0, nil, nil
 	}
 
-	// Case: \n
-	if i := strings.Index(string(data), "\n"); i >= 0 {
-		return i + 1, data[0:i], nil
+	terminalWidth, _ := terminal.Width()
+	maxLen := int(terminalWidth * 2)
+
+	// find the first candidate split point: a newline/carriage-return, or
+	// the point at which the line is simply too long to keep buffering
+	splitAt := -1
+	if i := strings.IndexAny(string(data), "\n\r"); i >= 0 {
+		splitAt = i + 1
+	} else if len(data) > maxLen {
+		splitAt = maxLen
 	}
 
-	// Case: \r
-	if i := strings.Index(string(data), "\r"); i >= 0 {
-		return i + 1, data[0:i], nil
+	if splitAt == -1 {
+		// If at end of file with data return the data
+		if atEOF {
+			return len(data), data, nil
+		}
+		return 0, nil, nil
 	}
 
-	// Case: it's just too long
-	terminalWidth, _ := terminal.Width()
-	if len(data) > int(terminalWidth*2) {
-		return int(terminalWidth * 2), data[0:int(terminalWidth*2)], nil
+	// don't split in the middle of an in-flight CSI/OSC escape sequence
+	// (cursor motion, colors, etc.) -- extend the token to cover it whole,
+	// or ask for more data if it hasn't finished yet
+	for i := 0; i < splitAt && i < len(data); i++ {
+		if data[i] != 0x1b {
+			continue
+		}
+		end := ansiSequenceEnd(data, i)
+		if end == -1 {
+			if atEOF {
+				break
+			}
+			return 0, nil, nil
+		}
+		if end > splitAt {
+			splitAt = end
+		}
+		i = end - 1
 	}
 
-	// TODO: by some ansi escape sequences
-
-	// If at end of file with data return the data
-	if atEOF {
-		return len(data), data, nil
+	if splitAt > len(data) {
+		splitAt = len(data)
 	}
 
-	return
+	token = data[0:splitAt]
+	if splitAt > 0 && (data[splitAt-1] == '\n' || data[splitAt-1] == '\r') {
+		token = data[0 : splitAt-1]
+	}
+	return splitAt, token, nil
 }
 
 func (task *Task) run(resultChan chan CmdIR, waiter *sync.WaitGroup) {
 	task.Command.StartTime = time.Now()
-	mainLogChan <- LogItem{Name: task.Name, Message: boldyellow("Started Task: " + task.Name)}
+	progressBus.Publish(ProgressEvent{Type: VertexStarted, Vertex: task.Name, Status: StatusRunning, Time: task.Command.StartTime})
 	resultChan <- CmdIR{Task: task, Status: StatusRunning, ReturnCode: -1}
 	waiter.Add(1)
 	defer waiter.Done()
@@ -304,10 +378,17 @@ func (task *Task) run(resultChan chan CmdIR, waiter *sync.WaitGroup) {
 	task.LogChan = make(chan LogItem)
 	go SingleLogger(task.LogChan, task.Name, tempFile.Name())
 
-	stdoutPipe, _ := task.Command.Cmd.StdoutPipe()
-	stderrPipe, _ := task.Command.Cmd.StderrPipe()
+	executor, err := task.ExecutorConfig.Build()
+	if err != nil {
+		task.fail(resultChan, -1, err)
+		return
+	}
 
-	task.Command.Cmd.Start()
+	stdoutPipe, stderrPipe, cmdWaiter, err := executor.Start(task)
+	if err != nil {
+		task.fail(resultChan, -1, err)
+		return
+	}
 
 	readPipe := func(resultChan chan string, pipe io.ReadCloser) {
 		defer close(resultChan)
@@ -316,7 +397,13 @@ func (task *Task) run(resultChan chan CmdIR, waiter *sync.WaitGroup) {
 		scanner.Split(variableSplitFunc)
 		for scanner.Scan() {
 			message := scanner.Text()
-			resultChan <- vtclean.Clean(message, false)
+			if task.ShowTaskOutput {
+				// preserve escape sequences (cursor motion, color) so the
+				// tail pane and log file show the tool's real output
+				resultChan <- message
+			} else {
+				resultChan <- vtclean.Clean(message, false)
+			}
 		}
 	}
 
@@ -330,12 +417,14 @@ func (task *Task) run(resultChan chan CmdIR, waiter *sync.WaitGroup) {
 		case stdoutMsg, ok := <-stdoutChan:
 			if ok {
 				resultChan <- CmdIR{Task: task, Status: StatusRunning, Stdout: stdoutMsg, ReturnCode: -1}
+				progressBus.Publish(ProgressEvent{Type: VertexLog, Vertex: task.Name, Stream: "stdout", Line: stdoutMsg, Time: time.Now()})
 			} else {
 				stdoutChan = nil
 			}
 		case stderrMsg, ok := <-stderrChan:
 			if ok {
 				resultChan <- CmdIR{Task: task, Status: StatusRunning, Stderr: stderrMsg, ReturnCode: -1}
+				progressBus.Publish(ProgressEvent{Type: VertexLog, Vertex: task.Name, Stream: "stderr", Line: stderrMsg, Time: time.Now()})
 			} else {
 				stderrChan = nil
 			}
@@ -345,20 +434,22 @@ func (task *Task) run(resultChan chan CmdIR, waiter *sync.WaitGroup) {
 		}
 	}
 
-	var waitStatus syscall.WaitStatus
-
-	err := task.Command.Cmd.Wait()
+	cmdWaiter.Wait()
 	task.Command.StopTime = time.Now()
+	returnCode := cmdWaiter.ExitCode()
 
-	if exitError, ok := err.(*exec.ExitError); ok {
-		waitStatus = exitError.Sys().(syscall.WaitStatus)
-	} else {
-		waitStatus = task.Command.Cmd.ProcessState.Sys().(syscall.WaitStatus)
+	finalStatus := StatusSuccess
+	if returnCode != 0 && !task.IgnoreFailure {
+		finalStatus = StatusError
 	}
-
-	returnCode := waitStatus.ExitStatus()
-
-	mainLogChan <- LogItem{Name: task.Name, Message: boldyellow("Completed Task: " + task.Name + " (rc: " + strconv.Itoa(returnCode) + ")")}
+	progressBus.Publish(ProgressEvent{
+		Type:       VertexCompleted,
+		Vertex:     task.Name,
+		Status:     finalStatus,
+		ReturnCode: returnCode,
+		Duration:   task.Command.StopTime.Sub(task.Command.StartTime),
+		Time:       task.Command.StopTime,
+	})
 
 	if returnCode == 0 || task.IgnoreFailure {
 		resultChan <- CmdIR{Task: task, Status: StatusSuccess, Complete: true, ReturnCode: returnCode}
@@ -370,6 +461,89 @@ func (task *Task) run(resultChan chan CmdIR, waiter *sync.WaitGroup) {
 	}
 }
 
+// fail records a task that could not even be started by its Executor (e.g. a
+// bad SSH key or an unreachable Docker daemon) as a terminal error.
+func (task *Task) fail(resultChan chan CmdIR, returnCode int, err error) {
+	task.Command.StopTime = time.Now()
+	mainLogChan <- LogItem{Name: task.Name, Message: red("Failed to start task: " + err.Error())}
+	progressBus.Publish(ProgressEvent{Type: VertexCompleted, Vertex: task.Name, Status: StatusError, ReturnCode: returnCode, Time: task.Command.StopTime})
+	resultChan <- CmdIR{Task: task, Status: StatusError, Complete: true, ReturnCode: returnCode}
+	if task.StopOnFailure {
+		exitSignaled = true
+	}
+}
+
+// resetCommand clears the command's completion state for another attempt.
+// The underlying Executor builds a fresh Cmd/session on the next Start, since
+// neither can be reused once started and waited on.
+func (task *Task) resetCommand() {
+	task.Command.Complete = false
+	task.Command.ReturnCode = -1
+}
+
+// retry waits out the configured retry-delay backoff and re-runs the task,
+// used when a task fails but has retries remaining. The previous attempt's
+// log channel and file are closed first -- run() opens a fresh one for every
+// attempt, and nothing else will ever close these once it moves on.
+func (task *Task) retry(resultChan chan CmdIR, waiter *sync.WaitGroup) {
+	if task.LogChan != nil {
+		close(task.LogChan)
+	}
+	if task.LogFile != nil {
+		task.LogFile.Close()
+	}
+
+	if task.RetryDelay > 0 {
+		time.Sleep(task.RetryDelay)
+	}
+	task.resetCommand()
+	task.run(resultChan, waiter)
+}
+
+// cascadeBlocked marks, as a terminal failure, every not-yet-started task
+// that can never run because one of its dependencies already failed -- so a
+// task list with a failing task doesn't leave its dependents stuck at
+// StatusPending forever once the run otherwise drains to a stop. It repeats
+// until a pass marks nothing new, so a chain of dependents (B depends on A,
+// C depends on B) is cascaded all the way through in one call.
+func cascadeBlocked(dag *taskDAG, started map[*Task]bool) []*Task {
+	var blocked []*Task
+	for {
+		progressedThisPass := false
+		for _, t := range dag.tasks {
+			if t.Name == "" || started[t] {
+				continue
+			}
+			if dag.blocked(t) {
+				started[t] = true
+				t.Command.Complete = true
+				t.Command.Status = StatusError
+				t.Display.Values = LineInfo{Status: StatusError.Color("i"), Title: t.Name, Msg: red("Skipped: upstream dependency failed")}
+				blocked = append(blocked, t)
+				progressedThisPass = true
+			}
+		}
+		if !progressedThisPass {
+			break
+		}
+	}
+	return blocked
+}
+
+// shouldRun evaluates the task's when clause (if any) against the current
+// state of the other tasks in the dependency graph.
+func (task *Task) shouldRun(dag *taskDAG) bool {
+	if task.When == "" {
+		return true
+	}
+	ok, err := evaluateWhen(task.When, dag)
+	if err != nil {
+		mainLogChan <- LogItem{Name: task.Name, Message: red("invalid when clause: " + err.Error())}
+		return true
+	}
+	return ok
+}
+
 func (task *Task) EstimatedRuntime() float64 {
 	var etaSeconds float64
 	// finalize task by appending to the set of final tasks
@@ -377,6 +551,11 @@ func (task *Task) EstimatedRuntime() float64 {
 		etaSeconds += task.Command.EstimatedRuntime.Seconds()
 	}
 
+	if dag, err := buildTaskDAG(task.Tasks()); err == nil && dag.hasDependencies() {
+		etaSeconds += dag.criticalPathSeconds()
+		return etaSeconds
+	}
+
 	var maxParallelEstimatedRuntime float64
 	var taskEndSecond []float64
 	var currentSecond float64
@@ -426,12 +605,13 @@ func (task *Task) Eta() string {
 func (task *Task) Process() []*Task {
 
 	var (
-		curLine         int
-		lastStartedTask int
-		moves           int
-		failedTasks     []*Task
+		curLine     int
+		moves       int
+		failedTasks []*Task
 	)
 
+	startProgressBus()
+
 	spinner := spin.New()
 	ticker := time.NewTicker(150 * time.Millisecond)
 	if Options.Vintage {
@@ -441,6 +621,13 @@ func (task *Task) Process() []*Task {
 	tasks := task.Tasks()
 	var waiter sync.WaitGroup
 
+	dag, err := buildTaskDAG(tasks)
+	if err != nil {
+		mainLogChan <- LogItem{Name: task.Name, Message: red(err.Error())}
+		exitSignaled = true
+		return failedTasks
+	}
+
 	if !Options.Vintage {
 
 		// make room for the title of a parallel proc group
@@ -454,22 +641,75 @@ func (task *Task) Process() []*Task {
 			ansi.EraseInLine(2)
 			tasks[line].Command.Started = false
 			tasks[line].Display.Values = LineInfo{Status: StatusPending.Color("i"), Title: tasks[line].Name}
-			tasks[line].display(&curLine)
+			tasks[line].displayWithTail(&curLine)
 		}
 	}
 
 	var runningCmds int
-	for ; lastStartedTask < Options.MaxParallelCmds && lastStartedTask < len(tasks); lastStartedTask++ {
-		if Options.Vintage {
-			fmt.Println(bold(task.Name + " : " + tasks[lastStartedTask].Name))
-			fmt.Println(bold("Command: " + tasks[lastStartedTask].CmdString))
+	if stateStore != nil {
+		stateStore.ApplyCommandTimeCache()
+		for _, name := range Options.ForceTasks {
+			stateStore.Invalidate(name)
 		}
-		go tasks[lastStartedTask].run(resultChan, &waiter)
-		tasks[lastStartedTask].Command.Started = true
-		runningCmds++
 	}
+
+	started := make(map[*Task]bool, len(tasks))
 	groupSuccess := StatusSuccess
 
+	// scheduleReady starts every task whose dependencies are satisfied, up to
+	// Options.MaxParallelCmds, and immediately resolves tasks whose when
+	// clause evaluates to false (cascading through any tasks depending on
+	// them) without consuming a concurrency slot.
+	scheduleReady := func() {
+		for {
+			progressed := false
+			for _, next := range dag.ready(started) {
+				if !next.shouldRun(dag) {
+					started[next] = true
+					next.Command.Complete = true
+					next.Command.Status = StatusSkipped
+					completedTasks++
+					progressed = true
+					continue
+				}
+
+				if Options.Resume && stateStore != nil && stateStore.Reusable(next) {
+					started[next] = true
+					cached, _ := stateStore.Get(next.Name)
+					next.Command.Complete = true
+					next.Command.Status = cached.Status
+					next.Command.ReturnCode = cached.ReturnCode
+					next.Command.EstimatedRuntime = cached.EstimatedRuntime
+					next.Display.Values = LineInfo{Status: cached.Status.Color("i"), Title: next.Name, Msg: purple("(cached)")}
+					next.display(&curLine)
+					completedTasks++
+					progressed = true
+					continue
+				}
+
+				if runningCmds >= Options.MaxParallelCmds {
+					continue
+				}
+
+				started[next] = true
+				progressed = true
+
+				if Options.Vintage {
+					fmt.Println(bold(task.Name + " : " + next.Name))
+					fmt.Println(bold("Command: " + next.CmdString))
+				}
+				go next.run(resultChan, &waiter)
+				next.Command.Started = true
+				runningCmds++
+			}
+			if !progressed {
+				break
+			}
+		}
+	}
+
+	scheduleReady()
+
 	// just wait for stuff to come back
 	for runningCmds > 0 {
 		select {
@@ -482,7 +722,7 @@ func (task *Task) Process() []*Task {
 				} else {
 					taskObj.Display.Values.Spinner = spinner.Current()
 				}
-				taskObj.display(&curLine)
+				taskObj.displayWithTail(&curLine)
 			}
 
 			// update the summary line
@@ -493,28 +733,28 @@ func (task *Task) Process() []*Task {
 		case msgObj := <-resultChan:
 			eventTask := msgObj.Task
 
-			// update the state before displaying...
-			if msgObj.Complete {
+			// a failed task with retries remaining is re-run in place rather
+			// than being treated as a terminal completion
+			if msgObj.Complete && msgObj.Status == StatusError && !eventTask.IgnoreFailure && eventTask.Command.Attempt < eventTask.Retries {
+				eventTask.Command.Attempt++
+				mainLogChan <- LogItem{Name: eventTask.Name, Message: boldyellow(fmt.Sprintf("Retrying Task: %s (attempt %d/%d)", eventTask.Name, eventTask.Command.Attempt+1, eventTask.Retries+1))}
+				go eventTask.retry(resultChan, &waiter)
+			} else if msgObj.Complete {
 				completedTasks++
 				eventTask.Command.Complete = true
+				eventTask.Command.Status = msgObj.Status
 				eventTask.Command.ReturnCode = msgObj.ReturnCode
 				close(eventTask.LogChan)
 
 				commandTimeCache[eventTask.CmdString] = eventTask.Command.StopTime.Sub(eventTask.Command.StartTime)
 
-				runningCmds--
-				// if a thread has freed up, start the next task (if there are any left)
-				if lastStartedTask < len(tasks) {
-					if Options.Vintage {
-						fmt.Println(bold(task.Name + " : " + tasks[lastStartedTask].Name))
-						fmt.Println("Command: " + bold(tasks[lastStartedTask].CmdString))
-					}
-					go tasks[lastStartedTask].run(resultChan, &waiter)
-					tasks[lastStartedTask].Command.Started = true
-					runningCmds++
-					lastStartedTask++
+				if stateStore != nil {
+					stateStore.Record(eventTask)
+					stateStore.Save()
 				}
 
+				runningCmds--
+
 				if msgObj.Status == StatusError {
 					// update the group status to indicate a failed subtask
 					groupSuccess = StatusError
@@ -522,6 +762,23 @@ func (task *Task) Process() []*Task {
 					// keep note of the failed task for an after task report
 					failedTasks = append(failedTasks, eventTask)
 				}
+
+				// start any tasks that were only waiting on this one
+				scheduleReady()
+
+				// anything left pending that depended (transitively) on a
+				// task that just failed can never become ready -- surface
+				// it as a failure now instead of leaving it stuck forever
+				if blocked := cascadeBlocked(dag, started); len(blocked) > 0 {
+					groupSuccess = StatusError
+					completedTasks += len(blocked)
+					failedTasks = append(failedTasks, blocked...)
+					if !Options.Vintage {
+						for _, b := range blocked {
+							b.displayWithTail(&curLine)
+						}
+					}
+				}
 			}
 
 			// record in the log
@@ -534,9 +791,15 @@ func (task *Task) Process() []*Task {
 				}
 			}
 
-			// keep record of all stderr lines for an after task report
+			// keep record of all stdout/stderr lines for an after task report
+			// and for use in downstream `when` clauses
+			if msgObj.Stdout != "" {
+				eventTask.OutputBuffer.WriteString(msgObj.Stdout + "\n")
+				eventTask.Tail.Append(msgObj.Stdout)
+			}
 			if msgObj.Stderr != "" {
 				eventTask.ErrorBuffer.WriteString(msgObj.Stderr + "\n")
+				eventTask.Tail.Append(red(msgObj.Stderr))
 			}
 
 			// display...
@@ -558,7 +821,7 @@ func (task *Task) Process() []*Task {
 					eventTask.Display.Values = LineInfo{Status: msgObj.Status.Color("i"), Title: eventTask.Name, Msg: yellow(msgObj.Stdout), Spinner: spinner.Current(), Eta: eventTask.Eta()}
 				}
 
-				eventTask.display(&curLine)
+				eventTask.displayWithTail(&curLine)
 			}
 
 			// update the summary line
@@ -611,11 +874,13 @@ func (task *Task) Process() []*Task {
 				}
 				curLine -= moves
 			}
-			// erase all lines
-			for range tasks {
-				ansi.EraseInLine(2)
-				ansi.CursorDown(1)
-				curLine++
+			// erase all lines (including any live tail-buffer panes)
+			for _, t := range tasks {
+				for i := 0; i < 1+t.paneLines(); i++ {
+					ansi.EraseInLine(2)
+					ansi.CursorDown(1)
+					curLine++
+				}
 			}
 			// erase the summary line
 			if Options.ShowSummaryFooter {
@@ -638,7 +903,11 @@ func (task *Task) Process() []*Task {
 
 			// instead, leave all of the text on the screen...
 			// ...reset the cursor to the bottom of the section
-			moves = curLine - len(tasks)
+			var totalDisplayLines int
+			for _, t := range tasks {
+				totalDisplayLines += 1 + t.paneLines()
+			}
+			moves = curLine - totalDisplayLines
 			if moves != 0 {
 				if moves < 0 {
 					ansi.CursorDown(moves * -1)
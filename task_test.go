@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+)
+
+func TestAnsiSequenceEnd(t *testing.T) {
+	cases := []struct {
+		name string
+		data []byte
+		want int
+	}{
+		{name: "CSI color sequence", data: []byte("\x1b[31mred\x1b[0m"), want: 5},
+		{name: "CSI cursor motion", data: []byte("\x1b[2Krest"), want: 4},
+		{name: "OSC terminated by BEL", data: []byte("\x1b]0;title\x07rest"), want: 10},
+		{name: "OSC terminated by ST", data: []byte("\x1b]0;title\x1b\\rest"), want: 11},
+		{name: "incomplete CSI", data: []byte("\x1b[31"), want: -1},
+		{name: "incomplete OSC", data: []byte("\x1b]0;title"), want: -1},
+		{name: "escape at end of buffer", data: []byte("\x1b"), want: -1},
+		{name: "two-byte escape", data: []byte("\x1bcrest"), want: 2},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := ansiSequenceEnd(c.data, 0); got != c.want {
+				t.Fatalf("ansiSequenceEnd(%q, 0) = %d, want %d", c.data, got, c.want)
+			}
+		})
+	}
+}
+
+func TestVariableSplitFuncKeepsEscapeSequencesAtomic(t *testing.T) {
+	line := "\x1b[31mred\x1b[0m\n"
+	scanner := bufio.NewScanner(strings.NewReader(line + "second\n"))
+	scanner.Split(variableSplitFunc)
+
+	var tokens []string
+	for scanner.Scan() {
+		tokens = append(tokens, scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatalf("unexpected scan error: %v", err)
+	}
+
+	want := []string{"\x1b[31mred\x1b[0m", "second"}
+	if len(tokens) != len(want) {
+		t.Fatalf("got tokens %q, want %q", tokens, want)
+	}
+	for i := range want {
+		if tokens[i] != want[i] {
+			t.Fatalf("token %d = %q, want %q", i, tokens[i], want[i])
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// whenExprPattern recognizes the small set of predicates supported in a
+// task's `when:` clause: `<task>.success`, `<task>.failure`,
+// `<task>.rc == N` / `!= N`, and `<task>.output contains "needle"`.
+var whenExprPattern = regexp.MustCompile(`^\s*([A-Za-z0-9_\-]+)\.(rc|success|failure|output)\s*(==|!=|contains)?\s*(.*?)\s*$`)
+
+// evaluateWhen resolves a when clause against the exit code or captured
+// stdout of a previously scheduled task in the same dependency graph.
+func evaluateWhen(expr string, dag *taskDAG) (bool, error) {
+	matches := whenExprPattern.FindStringSubmatch(expr)
+	if matches == nil {
+		return false, fmt.Errorf("unrecognized when clause %q", expr)
+	}
+
+	taskName, field, op, rhs := matches[1], matches[2], matches[3], matches[4]
+
+	referenced, err := dag.resolve(taskName)
+	if err != nil {
+		return false, fmt.Errorf("when clause references %s", err)
+	}
+
+	switch field {
+	case "success":
+		return referenced.Command.Status == StatusSuccess, nil
+
+	case "failure":
+		return referenced.Command.Status == StatusError, nil
+
+	case "rc":
+		want, err := strconv.Atoi(strings.TrimSpace(rhs))
+		if err != nil {
+			return false, fmt.Errorf("invalid rc comparison in when clause %q", expr)
+		}
+		if op == "!=" {
+			return referenced.Command.ReturnCode != want, nil
+		}
+		return referenced.Command.ReturnCode == want, nil
+
+	case "output":
+		needle := strings.Trim(strings.TrimSpace(rhs), `"`)
+		return strings.Contains(referenced.OutputBuffer.String(), needle), nil
+	}
+
+	return false, fmt.Errorf("unsupported when clause %q", expr)
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestEvaluateWhen(t *testing.T) {
+	upstream := newTestTask("build")
+	upstream.Command.Status = StatusSuccess
+	upstream.Command.ReturnCode = 0
+	upstream.OutputBuffer = bytes.NewBufferString("all tests passed\n")
+
+	dag, err := buildTaskDAG([]*Task{upstream})
+	if err != nil {
+		t.Fatalf("unexpected error building dag: %v", err)
+	}
+
+	cases := []struct {
+		name    string
+		expr    string
+		want    bool
+		wantErr bool
+	}{
+		{name: "success true", expr: "build.success", want: true},
+		{name: "failure false", expr: "build.failure", want: false},
+		{name: "rc equals", expr: "build.rc == 0", want: true},
+		{name: "rc not equals", expr: "build.rc != 0", want: false},
+		{name: "output contains match", expr: `build.output contains "tests passed"`, want: true},
+		{name: "output contains no match", expr: `build.output contains "nope"`, want: false},
+		{name: "unknown task", expr: "nope.success", wantErr: true},
+		{name: "garbage expression", expr: "not a when clause", wantErr: true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := evaluateWhen(c.expr, dag)
+			if c.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error for %q", c.expr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error for %q: %v", c.expr, err)
+			}
+			if got != c.want {
+				t.Fatalf("evaluateWhen(%q) = %v, want %v", c.expr, got, c.want)
+			}
+		})
+	}
+}